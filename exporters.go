@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/xuri/excelize/v2"
+)
+
+// Exporter turns a stream of header/row writes into one of the formats the
+// /download endpoint can negotiate. Implementations own the response writer
+// and are responsible for flushing/finalizing their output in Close.
+type Exporter interface {
+	ContentType() string
+	Filename() string
+	WriteHeader(headers []string) error
+	WriteRow(row []any) error
+	Close() error
+}
+
+// newExporter resolves the requested export format to an Exporter, defaulting
+// to xlsx when the format is empty or unrecognized.
+func newExporter(format string, w io.Writer) (Exporter, error) {
+	switch format {
+	case "", "xlsx":
+		return newXLSXExporter(w)
+	case "ods":
+		return newODSExporter(w)
+	case "csv":
+		return newCSVExporter(w), nil
+	case "jsonl":
+		return newJSONLExporter(w), nil
+	case "pdf":
+		return newPDFExporter(w), nil
+	default:
+		return nil, fmt.Errorf("unsupported export format: %q", format)
+	}
+}
+
+// resolveFormat picks the export format from the ?format= query param,
+// falling back to the Accept header, and finally xlsx.
+func resolveFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+
+	switch r.Header.Get("Accept") {
+	case "text/csv":
+		return "csv"
+	case "application/x-ndjson", "application/jsonl":
+		return "jsonl"
+	case "application/vnd.oasis.opendocument.spreadsheet":
+		return "ods"
+	case "application/pdf":
+		return "pdf"
+	default:
+		return "xlsx"
+	}
+}
+
+// StyledExporter is an optional capability: exporters that understand
+// per-column formatting metadata (widths, number formats, bold columns)
+// implement it so streamDownload can hand over ColumnSpecs instead of plain
+// header strings.
+type StyledExporter interface {
+	WriteColumns(specs []ColumnSpec) error
+}
+
+// numFmtForSpec maps a ColumnSpec's "format" tag value to an excelize custom
+// number format string.
+func numFmtForSpec(format string) string {
+	switch {
+	case format == "date":
+		return "yyyy-mm-dd"
+	case strings.HasPrefix(format, "number"):
+		if _, numFmt, ok := strings.Cut(format, ";"); ok {
+			return numFmt
+		}
+		return "0.00"
+	default:
+		return ""
+	}
+}
+
+// xlsxExporter streams rows straight into an excelize StreamWriter, as wired
+// up for the /download endpoint.
+type xlsxExporter struct {
+	w           io.Writer
+	file        *excelize.File
+	sw          *excelize.StreamWriter
+	sheetName   string
+	rowNum      int
+	lastDataRow int
+	colStyles   []int
+	colFormats  []string
+}
+
+func newXLSXExporter(w io.Writer) (*xlsxExporter, error) {
+	file := excelize.NewFile()
+	sheetName := "Sheet1"
+	sw, err := file.NewStreamWriter(sheetName)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error creating stream writer: %w", err)
+	}
+	return &xlsxExporter{w: w, file: file, sw: sw, sheetName: sheetName, rowNum: 2, lastDataRow: 1}, nil
+}
+
+func (e *xlsxExporter) ContentType() string {
+	return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+}
+
+func (e *xlsxExporter) Filename() string { return "data.xlsx" }
+
+func (e *xlsxExporter) WriteHeader(headers []string) error {
+	return e.sw.SetRow("A1", toAnyRow(headers))
+}
+
+// WriteColumns applies each column's width and number/bold styling, freezes
+// the header row, and writes a bold header row - the schema-driven
+// formatting the xlsx tag grammar describes.
+func (e *xlsxExporter) WriteColumns(specs []ColumnSpec) error {
+	headerStyleID, err := e.file.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return fmt.Errorf("error creating header style: %w", err)
+	}
+
+	colStyles := make([]int, len(specs))
+	colFormats := make([]string, len(specs))
+	headerRow := make([]any, len(specs))
+	for i, spec := range specs {
+		headerRow[i] = spec.Header
+		colFormats[i] = spec.Format
+
+		if spec.Width > 0 {
+			if err := e.sw.SetColWidth(i+1, i+1, spec.Width); err != nil {
+				return fmt.Errorf("error setting column width: %w", err)
+			}
+		}
+
+		if spec.Format == "" && !spec.Bold {
+			continue
+		}
+
+		style := &excelize.Style{}
+		if numFmt := numFmtForSpec(spec.Format); numFmt != "" {
+			style.CustomNumFmt = &numFmt
+		}
+		if spec.Bold {
+			style.Font = &excelize.Font{Bold: true}
+		}
+		styleID, err := e.file.NewStyle(style)
+		if err != nil {
+			return fmt.Errorf("error creating column style: %w", err)
+		}
+		colStyles[i] = styleID
+	}
+	e.colStyles = colStyles
+	e.colFormats = colFormats
+
+	panes := &excelize.Panes{Freeze: true, Split: false, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"}
+	if err := e.sw.SetPanes(panes); err != nil {
+		return fmt.Errorf("error freezing header row: %w", err)
+	}
+
+	return e.sw.SetRow("A1", headerRow, excelize.RowOpts{StyleID: headerStyleID})
+}
+
+// dateLayouts are the source string formats a "format=date" column is
+// recognized in; the upstream API sends plain ISO dates, so that's tried
+// first.
+var dateLayouts = []string{"2006-01-02", time.RFC3339}
+
+// asDateValue converts a "format=date" column's value to a time.Time so
+// excelize stores it as a real date serial. A NumFmt on a text cell is a
+// no-op in Excel, so a value that can't be parsed as a date is left as-is
+// rather than styled misleadingly.
+func asDateValue(v any) any {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return v
+}
+
+func (e *xlsxExporter) WriteRow(row []any) error {
+	cell := fmt.Sprintf("A%d", e.rowNum)
+	e.lastDataRow = e.rowNum
+	e.rowNum++
+
+	values := row
+	if len(e.colStyles) == len(row) {
+		values = make([]any, len(row))
+		for i, v := range row {
+			if len(e.colFormats) == len(row) && e.colFormats[i] == "date" {
+				v = asDateValue(v)
+			}
+			if e.colStyles[i] != 0 {
+				values[i] = excelize.Cell{StyleID: e.colStyles[i], Value: v}
+			} else {
+				values[i] = v
+			}
+		}
+	}
+
+	return e.sw.SetRow(cell, values)
+}
+
+func (e *xlsxExporter) Close() error {
+	defer e.file.Close()
+	if err := e.sw.Flush(); err != nil {
+		return fmt.Errorf("error flushing stream writer: %w", err)
+	}
+
+	if len(e.colStyles) > 0 {
+		lastCol, err := excelize.ColumnNumberToName(len(e.colStyles))
+		if err != nil {
+			return fmt.Errorf("error resolving last column: %w", err)
+		}
+		filterRange := fmt.Sprintf("A1:%s%d", lastCol, e.lastDataRow)
+		if err := e.file.AutoFilter(e.sheetName, filterRange, nil); err != nil {
+			return fmt.Errorf("error applying auto filter: %w", err)
+		}
+	}
+
+	return e.file.Write(e.w)
+}
+
+// odsExporter reuses the xlsx stream writer - excelize writes the OOXML
+// container underneath either extension - but advertises the OpenDocument
+// content type and filename consumers expect.
+type odsExporter struct {
+	*xlsxExporter
+}
+
+func newODSExporter(w io.Writer) (*odsExporter, error) {
+	xlsx, err := newXLSXExporter(w)
+	if err != nil {
+		return nil, err
+	}
+	return &odsExporter{xlsxExporter: xlsx}, nil
+}
+
+func (e *odsExporter) ContentType() string {
+	return "application/vnd.oasis.opendocument.spreadsheet"
+}
+
+func (e *odsExporter) Filename() string { return "data.ods" }
+
+// csvExporter writes rows directly to the response as they arrive.
+type csvExporter struct {
+	cw *csv.Writer
+}
+
+func newCSVExporter(w io.Writer) *csvExporter {
+	return &csvExporter{cw: csv.NewWriter(w)}
+}
+
+func (e *csvExporter) ContentType() string { return "text/csv" }
+
+func (e *csvExporter) Filename() string { return "data.csv" }
+
+func (e *csvExporter) WriteHeader(headers []string) error {
+	return e.cw.Write(headers)
+}
+
+func (e *csvExporter) WriteRow(row []any) error {
+	record := make([]string, len(row))
+	for i, v := range row {
+		record[i] = fmt.Sprintf("%v", v)
+	}
+	return e.cw.Write(record)
+}
+
+func (e *csvExporter) Close() error {
+	e.cw.Flush()
+	return e.cw.Error()
+}
+
+// jsonlExporter emits one JSON object per row, keyed by header, so consumers
+// can pipe the response straight into jq.
+type jsonlExporter struct {
+	headers []string
+	enc     *json.Encoder
+}
+
+func newJSONLExporter(w io.Writer) *jsonlExporter {
+	return &jsonlExporter{enc: json.NewEncoder(w)}
+}
+
+func (e *jsonlExporter) ContentType() string { return "application/x-ndjson" }
+
+func (e *jsonlExporter) Filename() string { return "data.jsonl" }
+
+func (e *jsonlExporter) WriteHeader(headers []string) error {
+	e.headers = headers
+	return nil
+}
+
+func (e *jsonlExporter) WriteRow(row []any) error {
+	record := make(map[string]any, len(row))
+	for i, v := range row {
+		if i < len(e.headers) {
+			record[e.headers[i]] = v
+		}
+	}
+	return e.enc.Encode(record)
+}
+
+func (e *jsonlExporter) Close() error { return nil }
+
+// pdfExporter renders a simple landscape table, one row of cells per record.
+type pdfExporter struct {
+	w        io.Writer
+	pdf      *gofpdf.Fpdf
+	colWidth float64
+}
+
+func newPDFExporter(w io.Writer) *pdfExporter {
+	pdf := gofpdf.New("L", "mm", "A4", "")
+	pdf.AddPage()
+	return &pdfExporter{w: w, pdf: pdf}
+}
+
+func (e *pdfExporter) ContentType() string { return "application/pdf" }
+
+func (e *pdfExporter) Filename() string { return "data.pdf" }
+
+// columnWidth spreads the page's usable width evenly across numCols, so a
+// report with many columns (the 23-column DATA model, for one) still fits on
+// the page instead of running off the right margin at a fixed 30mm/column.
+func columnWidth(pdf *gofpdf.Fpdf, numCols int) float64 {
+	if numCols == 0 {
+		return 0
+	}
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	return (pageWidth - left - right) / float64(numCols)
+}
+
+func (e *pdfExporter) WriteHeader(headers []string) error {
+	e.colWidth = columnWidth(e.pdf, len(headers))
+
+	e.pdf.SetFont("Arial", "B", 8)
+	for _, header := range headers {
+		e.pdf.CellFormat(e.colWidth, 7, header, "1", 0, "", false, 0, "")
+	}
+	e.pdf.Ln(-1)
+	e.pdf.SetFont("Arial", "", 8)
+	return nil
+}
+
+func (e *pdfExporter) WriteRow(row []any) error {
+	for _, v := range row {
+		e.pdf.CellFormat(e.colWidth, 6, fmt.Sprintf("%v", v), "1", 0, "", false, 0, "")
+	}
+	e.pdf.Ln(-1)
+	return nil
+}
+
+func (e *pdfExporter) Close() error {
+	return e.pdf.Output(e.w)
+}
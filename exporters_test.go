@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		accept string
+		want   string
+	}{
+		{name: "query param wins", query: "csv", accept: "application/pdf", want: "csv"},
+		{name: "csv from Accept header", accept: "text/csv", want: "csv"},
+		{name: "jsonl from Accept header", accept: "application/x-ndjson", want: "jsonl"},
+		{name: "ods from Accept header", accept: "application/vnd.oasis.opendocument.spreadsheet", want: "ods"},
+		{name: "pdf from Accept header", accept: "application/pdf", want: "pdf"},
+		{name: "defaults to xlsx", want: "xlsx"},
+		{name: "unrecognized Accept header defaults to xlsx", accept: "text/plain", want: "xlsx"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/download/solicitud", nil)
+			if tt.query != "" {
+				q := r.URL.Query()
+				q.Set("format", tt.query)
+				r.URL.RawQuery = q.Encode()
+			}
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+
+			if got := resolveFormat(r); got != tt.want {
+				t.Errorf("resolveFormat() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewExporter(t *testing.T) {
+	tests := []struct {
+		format          string
+		wantContentType string
+		wantFilename    string
+		wantErr         bool
+	}{
+		{format: "", wantContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", wantFilename: "data.xlsx"},
+		{format: "xlsx", wantContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", wantFilename: "data.xlsx"},
+		{format: "ods", wantContentType: "application/vnd.oasis.opendocument.spreadsheet", wantFilename: "data.ods"},
+		{format: "csv", wantContentType: "text/csv", wantFilename: "data.csv"},
+		{format: "jsonl", wantContentType: "application/x-ndjson", wantFilename: "data.jsonl"},
+		{format: "pdf", wantContentType: "application/pdf", wantFilename: "data.pdf"},
+		{format: "yaml", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			exporter, err := newExporter(tt.format, &bytes.Buffer{})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("newExporter(%q) = nil error, want one", tt.format)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newExporter(%q): %v", tt.format, err)
+			}
+			if got := exporter.ContentType(); got != tt.wantContentType {
+				t.Errorf("ContentType() = %q, want %q", got, tt.wantContentType)
+			}
+			if got := exporter.Filename(); got != tt.wantFilename {
+				t.Errorf("Filename() = %q, want %q", got, tt.wantFilename)
+			}
+		})
+	}
+}
+
+// TestTrackingResponseWriterDetectsPartialWrite exercises the wrapper
+// makeDownloadHandler relies on to tell whether it's still safe to send a
+// textual error (nothing written yet) or must abort the connection instead
+// (bytes already reached the client, as csv/jsonl do on every row).
+func TestTrackingResponseWriterDetectsPartialWrite(t *testing.T) {
+	rec := httptest.NewRecorder()
+	tw := &trackingResponseWriter{ResponseWriter: rec}
+
+	if tw.wrote {
+		t.Fatal("wrote = true before any Write call")
+	}
+
+	if _, err := tw.Write([]byte("partial,csv,row\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if !tw.wrote {
+		t.Fatal("wrote = false after a non-empty Write call")
+	}
+
+	if unwrapped := tw.Unwrap(); unwrapped != rec {
+		t.Errorf("Unwrap() = %v, want the underlying ResponseWriter", unwrapped)
+	}
+}
+
+func TestTrackingResponseWriterIgnoresEmptyWrite(t *testing.T) {
+	rec := httptest.NewRecorder()
+	tw := &trackingResponseWriter{ResponseWriter: rec}
+
+	if _, err := tw.Write(nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if tw.wrote {
+		t.Fatal("wrote = true after an empty Write call")
+	}
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+)
+
+// JobStatus is the lifecycle state of an async export job.
+type JobStatus string
+
+const (
+	JobPending JobStatus = "pending"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// ExportJob is one queued/running/finished export, backed by a row in the
+// jobs SQLite database so a server restart doesn't lose in-flight work.
+type ExportJob struct {
+	ID        string
+	Report    string
+	Query     string
+	Format    string
+	Status    JobStatus
+	Progress  int
+	RowCount  int
+	Error     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// JobStore persists ExportJobs in SQLite. Writes are serialized through mu
+// because modernc.org/sqlite still returns SQLITE_BUSY under concurrent
+// writers even with WAL and a busy_timeout once two goroutines land in the
+// same millisecond, which the export worker pool does routinely.
+type JobStore struct {
+	db *sql.DB
+	mu sync.Mutex
+}
+
+const createJobsTableSQL = `
+CREATE TABLE IF NOT EXISTS export_jobs (
+	id         TEXT PRIMARY KEY,
+	report     TEXT NOT NULL,
+	query      TEXT NOT NULL,
+	format     TEXT NOT NULL,
+	status     TEXT NOT NULL,
+	progress   INTEGER NOT NULL DEFAULT 0,
+	row_count  INTEGER NOT NULL DEFAULT 0,
+	error      TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+)`
+
+// jobStoreDSN enables WAL so readers (status polling) don't block on writers
+// (progress updates), and a busy_timeout so a writer that does lose the race
+// for the single write lock retries internally instead of failing immediately
+// with SQLITE_BUSY.
+const jobStoreDSN = "%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+
+// openJobStore opens (and migrates) the jobs database at path, reusing the
+// connection pool limits already tuned for this service.
+func openJobStore(path string) (*JobStore, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf(jobStoreDSN, path))
+	if err != nil {
+		return nil, fmt.Errorf("error opening job store: %w", err)
+	}
+
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+	db.SetConnMaxLifetime(connMaxLifetime)
+
+	if _, err := db.Exec(createJobsTableSQL); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating jobs table: %w", err)
+	}
+
+	return &JobStore{db: db}, nil
+}
+
+func (s *JobStore) Create(job *ExportJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.db.Exec(
+		`INSERT INTO export_jobs (id, report, query, format, status, progress, row_count, error, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		job.ID, job.Report, job.Query, job.Format, job.Status, job.Progress, job.RowCount, job.Error, job.CreatedAt, job.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating job %s: %w", job.ID, err)
+	}
+	return nil
+}
+
+func (s *JobStore) Get(id string) (*ExportJob, error) {
+	var job ExportJob
+	row := s.db.QueryRow(
+		`SELECT id, report, query, format, status, progress, row_count, error, created_at, updated_at
+		 FROM export_jobs WHERE id = ?`, id,
+	)
+	if err := row.Scan(&job.ID, &job.Report, &job.Query, &job.Format, &job.Status, &job.Progress, &job.RowCount, &job.Error, &job.CreatedAt, &job.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting job %s: %w", id, err)
+	}
+	return &job, nil
+}
+
+func (s *JobStore) Update(job *ExportJob) error {
+	job.UpdatedAt = time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.db.Exec(
+		`UPDATE export_jobs SET status = ?, progress = ?, row_count = ?, error = ?, updated_at = ? WHERE id = ?`,
+		job.Status, job.Progress, job.RowCount, job.Error, job.UpdatedAt, job.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating job %s: %w", job.ID, err)
+	}
+	return nil
+}
+
+func (s *JobStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.db.Exec(`DELETE FROM export_jobs WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting job %s: %w", id, err)
+	}
+	return nil
+}
+
+// PendingJobs returns jobs left pending or running by a prior process, so
+// they can be requeued on startup instead of silently stalling forever.
+func (s *JobStore) PendingJobs() ([]*ExportJob, error) {
+	rows, err := s.db.Query(
+		`SELECT id, report, query, format, status, progress, row_count, error, created_at, updated_at
+		 FROM export_jobs WHERE status IN (?, ?)`, JobPending, JobRunning,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error listing pending jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*ExportJob
+	for rows.Next() {
+		var job ExportJob
+		if err := rows.Scan(&job.ID, &job.Report, &job.Query, &job.Format, &job.Status, &job.Progress, &job.RowCount, &job.Error, &job.CreatedAt, &job.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning pending job: %w", err)
+		}
+		jobs = append(jobs, &job)
+	}
+	return jobs, rows.Err()
+}
+
+// ExportStore persists finished export files, keyed by job ID. localExportStore
+// and s3ExportStore are the two backends this service ships with.
+type ExportStore interface {
+	Create(id string) (io.WriteCloser, error)
+	Open(id string) (io.ReadCloser, error)
+	Remove(id string) error
+}
+
+// newExportStore picks the export file backend from EXPORT_STORAGE
+// ("local", the default, or "s3").
+func newExportStore(ctx context.Context) (ExportStore, error) {
+	switch os.Getenv("EXPORT_STORAGE") {
+	case "s3":
+		bucket := os.Getenv("EXPORT_S3_BUCKET")
+		if bucket == "" {
+			return nil, fmt.Errorf("EXPORT_S3_BUCKET must be set when EXPORT_STORAGE=s3")
+		}
+		return newS3ExportStore(ctx, bucket)
+	default:
+		return newLocalExportStore(os.Getenv("EXPORT_DIR"))
+	}
+}
+
+type localExportStore struct {
+	dir string
+}
+
+func newLocalExportStore(dir string) (*localExportStore, error) {
+	if dir == "" {
+		dir = "exports"
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating export dir %q: %w", dir, err)
+	}
+	return &localExportStore{dir: dir}, nil
+}
+
+func (s *localExportStore) path(id string) string { return filepath.Join(s.dir, id) }
+
+func (s *localExportStore) Create(id string) (io.WriteCloser, error) {
+	return os.Create(s.path(id))
+}
+
+func (s *localExportStore) Open(id string) (io.ReadCloser, error) {
+	return os.Open(s.path(id))
+}
+
+func (s *localExportStore) Remove(id string) error {
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// s3ExportStore streams export files to/from S3 so the worker pool doesn't
+// need local disk for the finished output.
+type s3ExportStore struct {
+	bucket string
+	client *s3.Client
+}
+
+func newS3ExportStore(ctx context.Context, bucket string) (*s3ExportStore, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error loading aws config: %w", err)
+	}
+	return &s3ExportStore{bucket: bucket, client: s3.NewFromConfig(cfg)}, nil
+}
+
+func (s *s3ExportStore) Create(id string) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := manager.NewUploader(s.client).Upload(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(id),
+			Body:   pr,
+		})
+		pr.CloseWithError(err)
+	}()
+	return pw, nil
+}
+
+func (s *s3ExportStore) Open(id string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(id),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting export %s: %w", id, err)
+	}
+	return out.Body, nil
+}
+
+func (s *s3ExportStore) Remove(id string) error {
+	_, err := s.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(id),
+	})
+	return err
+}
+
+// ExportWorkerPool runs queued export jobs against a bounded set of
+// goroutines, writing finished files into store and job state into jobs.
+type ExportWorkerPool struct {
+	jobs     *JobStore
+	registry *ReportRegistry
+	store    ExportStore
+	queue    chan string
+}
+
+func newExportWorkerPool(workers int, jobs *JobStore, registry *ReportRegistry, store ExportStore) *ExportWorkerPool {
+	pool := &ExportWorkerPool{jobs: jobs, registry: registry, store: store, queue: make(chan string, 100)}
+	for i := 0; i < workers; i++ {
+		go pool.worker()
+	}
+	return pool
+}
+
+// Enqueue schedules an already-persisted job for a worker to pick up.
+func (p *ExportWorkerPool) Enqueue(id string) {
+	p.queue <- id
+}
+
+func (p *ExportWorkerPool) worker() {
+	for id := range p.queue {
+		p.run(id)
+	}
+}
+
+func (p *ExportWorkerPool) run(id string) {
+	job, err := p.jobs.Get(id)
+	if err != nil || job == nil {
+		log.Printf("export job %s: error loading job: %v", id, err)
+		return
+	}
+
+	job.Status = JobRunning
+	if err := p.jobs.Update(job); err != nil {
+		log.Printf("export job %s: error marking running: %v", id, err)
+	}
+
+	if err := p.export(job); err != nil {
+		log.Printf("export job %s: %v", id, err)
+		job.Status = JobFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = JobDone
+	}
+
+	if err := p.jobs.Update(job); err != nil {
+		log.Printf("export job %s: error saving result: %v", id, err)
+	}
+}
+
+func (p *ExportWorkerPool) export(job *ExportJob) error {
+	def, ok := p.registry.Get(job.Report)
+	if !ok {
+		return fmt.Errorf("unknown report %q", job.Report)
+	}
+
+	query, err := url.ParseQuery(job.Query)
+	if err != nil {
+		return fmt.Errorf("error parsing stored query: %w", err)
+	}
+
+	out, err := p.store.Create(job.ID)
+	if err != nil {
+		return fmt.Errorf("error creating export file: %w", err)
+	}
+	defer out.Close()
+
+	exporter, err := newExporter(job.Format, out)
+	if err != nil {
+		return err
+	}
+
+	rowCount, err := runExport(context.Background(), exporter, def, query, func(rowsDone, total int) {
+		job.RowCount = rowsDone
+		if total > 0 {
+			job.Progress = min(100, rowsDone*100/total)
+		}
+		if err := p.jobs.Update(job); err != nil {
+			log.Printf("export job %s: error saving progress: %v", job.ID, err)
+		}
+	})
+	job.RowCount = rowCount
+	if err != nil {
+		return err
+	}
+
+	job.Progress = 100
+	return nil
+}
+
+// makeExportsHandler builds the POST /exports/{report} handler: it validates
+// the report and required query params, persists a pending job, and hands it
+// to the worker pool.
+func makeExportsHandler(jobs *JobStore, registry *ReportRegistry, pool *ExportWorkerPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := r.PathValue("report")
+		def, ok := registry.Get(report)
+		if !ok {
+			handleError(w, nil, fmt.Sprintf("Unknown report %q", report), http.StatusNotFound)
+			return
+		}
+
+		if err := requireQueryParams(def, r.URL.Query()); err != nil {
+			handleError(w, err, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		job := &ExportJob{
+			ID:        uuid.NewString(),
+			Report:    report,
+			Query:     r.URL.Query().Encode(),
+			Format:    resolveFormat(r),
+			Status:    JobPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+
+		if err := jobs.Create(job); err != nil {
+			handleError(w, err, "Error creating export job", http.StatusInternalServerError)
+			return
+		}
+
+		pool.Enqueue(job.ID)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, `{"id":%q}`, job.ID)
+	}
+}
+
+// makeExportStatusHandler builds the GET /exports/{id} polling endpoint.
+func makeExportStatusHandler(jobs *JobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, err := jobs.Get(r.PathValue("id"))
+		if err != nil {
+			handleError(w, err, "Error loading export job", http.StatusInternalServerError)
+			return
+		}
+		if job == nil {
+			handleError(w, nil, "Export job not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":%q,"report":%q,"status":%q,"progress":%d,"row_count":%d,"error":%q}`,
+			job.ID, job.Report, job.Status, job.Progress, job.RowCount, job.Error)
+	}
+}
+
+// makeExportDownloadHandler builds the GET /exports/{id}/download endpoint
+// that streams the finished export file once the job is done.
+func makeExportDownloadHandler(jobs *JobStore, store ExportStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, err := jobs.Get(r.PathValue("id"))
+		if err != nil {
+			handleError(w, err, "Error loading export job", http.StatusInternalServerError)
+			return
+		}
+		if job == nil {
+			handleError(w, nil, "Export job not found", http.StatusNotFound)
+			return
+		}
+		if job.Status != JobDone {
+			handleError(w, nil, fmt.Sprintf("Export job is %s, not done", job.Status), http.StatusConflict)
+			return
+		}
+
+		// Large exports can outlast the server's global WriteTimeout just as
+		// easily on the way down to the client as they did on the way up from
+		// the upstream API; extend the deadline the same way /download/{report}
+		// does rather than cutting off a slow client mid-file.
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Now().Add(30 * time.Minute)); err != nil {
+			log.Printf("unable to extend write deadline: %v", err)
+		}
+
+		file, err := store.Open(job.ID)
+		if err != nil {
+			handleError(w, err, "Error opening export file", http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=data.%s", job.Format))
+		if _, err := io.Copy(w, file); err != nil {
+			log.Printf("export job %s: error streaming download: %v", job.ID, err)
+		}
+	}
+}
+
+// makeExportDeleteHandler builds the DELETE /exports/{id} cleanup endpoint.
+func makeExportDeleteHandler(jobs *JobStore, store ExportStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		job, err := jobs.Get(id)
+		if err != nil {
+			handleError(w, err, "Error loading export job", http.StatusInternalServerError)
+			return
+		}
+		if job == nil {
+			handleError(w, nil, "Export job not found", http.StatusNotFound)
+			return
+		}
+
+		if err := store.Remove(id); err != nil {
+			handleError(w, err, "Error removing export file", http.StatusInternalServerError)
+			return
+		}
+		if err := jobs.Delete(id); err != nil {
+			handleError(w, err, "Error deleting export job", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
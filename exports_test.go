@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestJobStoreConcurrentWrites drives Create/Update from many goroutines at
+// once, the same load pattern the worker pool puts on the store as every job
+// reports progress - the exact scenario the eee620b fix (WAL + busy_timeout +
+// serialized writes) claims to make safe.
+func TestJobStoreConcurrentWrites(t *testing.T) {
+	store, err := openJobStore(filepath.Join(t.TempDir(), "jobs.db"))
+	if err != nil {
+		t.Fatalf("openJobStore: %v", err)
+	}
+
+	const jobCount = 20
+	const updatesPerJob = 5
+
+	jobs := make([]*ExportJob, jobCount)
+	for i := range jobs {
+		now := time.Now()
+		jobs[i] = &ExportJob{
+			ID:        fmt.Sprintf("job-%d", i),
+			Report:    "solicitud",
+			Format:    "xlsx",
+			Status:    JobPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, jobCount*(1+updatesPerJob))
+
+	for _, job := range jobs {
+		job := job
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := store.Create(job); err != nil {
+				errs <- err
+				return
+			}
+			for i := 0; i < updatesPerJob; i++ {
+				job.Status = JobRunning
+				job.Progress = i * 20
+				if err := store.Update(job); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("concurrent job store write failed: %v", err)
+	}
+
+	for _, job := range jobs {
+		got, err := store.Get(job.ID)
+		if err != nil {
+			t.Fatalf("Get(%s): %v", job.ID, err)
+		}
+		if got == nil {
+			t.Fatalf("Get(%s) = nil, want the job written concurrently above", job.ID)
+		}
+		if got.Progress != (updatesPerJob-1)*20 {
+			t.Errorf("job %s: Progress = %d, want %d (a concurrent update was silently dropped)", job.ID, got.Progress, (updatesPerJob-1)*20)
+		}
+	}
+}
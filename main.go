@@ -1,25 +1,26 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"reflect"
+	"strconv"
 	"time"
 
 	"github.com/joho/godotenv"
-	"github.com/xuri/excelize/v2"
 )
 
 const (
-	serverAddress   = "localhost:8080"
-	maxOpenConns    = 25
-	maxIdleConns    = 5
-	connMaxLifetime = 5 * time.Minute
+	serverAddress        = "localhost:8080"
+	maxOpenConns         = 25
+	maxIdleConns         = 5
+	connMaxLifetime      = 5 * time.Minute
+	defaultReportsConfig = "reports.json"
+	defaultJobsDB        = "jobs.db"
+	defaultExportWorkers = 4
 )
 
 type DATA struct {
@@ -32,7 +33,7 @@ type DATA struct {
 	Telefono        string `json:"telefono" xlsx:"Telefono"`
 	Institucion     string `json:"institucion" xlsx:"Institucion"`
 	Edad            int    `json:"edad" xlsx:"Edad"`
-	FechaNacimiento string `json:"fecha_nacimiento" xlsx:"Fecha Nacimiento"`
+	FechaNacimiento string `json:"fecha_nacimiento" xlsx:"Fecha Nacimiento,format=date,width=18,style=bold"`
 	Genero          string `json:"genero" xlsx:"Genero"`
 	Direccion       string `json:"direccion" xlsx:"Direccion"`
 	Municipio       string `json:"municipio" xlsx:"Municipio"`
@@ -44,7 +45,7 @@ type DATA struct {
 	PaisNombre      string `json:"pais_nombre" xlsx:"Pais"`
 	Modalidad       string `json:"modalidad" xlsx:"Modalidad"`
 	EstadoNombre    string `json:"estado_nombre" xlsx:"Estado"`
-	Puntaje         int    `json:"puntaje" xlsx:"Puntaje"`
+	Puntaje         int    `json:"puntaje" xlsx:"Puntaje,format=number;0.00"`
 	Comentario      string `json:"comentario" xlsx:"Comentario"`
 }
 
@@ -83,6 +84,34 @@ func init() {
 }
 
 func main() {
+	registry, err := loadReportRegistry(reportsConfigPath())
+	if err != nil {
+		log.Fatalf("error loading report config: %v", err)
+	}
+
+	ctx := context.Background()
+
+	jobs, err := openJobStore(jobStorePath())
+	if err != nil {
+		log.Fatalf("error opening job store: %v", err)
+	}
+
+	exportStore, err := newExportStore(ctx)
+	if err != nil {
+		log.Fatalf("error opening export store: %v", err)
+	}
+
+	pool := newExportWorkerPool(exportWorkers(), jobs, registry, exportStore)
+
+	pending, err := jobs.PendingJobs()
+	if err != nil {
+		log.Fatalf("error listing pending export jobs: %v", err)
+	}
+	for _, job := range pending {
+		log.Printf("requeueing export job %s left %s by a prior run", job.ID, job.Status)
+		pool.Enqueue(job.ID)
+	}
+
 	server := &http.Server{
 		Addr:         "localhost:8080",
 		ReadTimeout:  5 * time.Second,
@@ -90,62 +119,43 @@ func main() {
 		Handler:      http.DefaultServeMux,
 	}
 
-	http.HandleFunc("GET /download", handler)
+	http.HandleFunc("GET /download/{report}", makeDownloadHandler(registry))
+	http.HandleFunc("POST /exports/{report}", makeExportsHandler(jobs, registry, pool))
+	http.HandleFunc("GET /exports/{id}", makeExportStatusHandler(jobs))
+	http.HandleFunc("GET /exports/{id}/download", makeExportDownloadHandler(jobs, exportStore))
+	http.HandleFunc("DELETE /exports/{id}", makeExportDeleteHandler(jobs, exportStore))
+
 	log.Printf("Starting server on %s", serverAddress)
 	log.Fatal(server.ListenAndServe())
 }
 
-func getHeaders[T any](model T) ([]string, error) {
-	var headers []string
-	t := reflect.TypeOf(model)
-
-	if t == nil {
-		return nil, fmt.Errorf("nil model type")
-	}
-
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
+// reportsConfigPath resolves the report registry config path, defaulting to
+// reports.json in the working directory.
+func reportsConfigPath() string {
+	if path := os.Getenv("REPORTS_CONFIG"); path != "" {
+		return path
 	}
+	return defaultReportsConfig
+}
 
-	for i := range t.NumField() {
-		field := t.Field(i)
-		if field.Type.Kind() == reflect.Struct && field.Anonymous {
-			nestedHeaders, err := getHeaders(reflect.New(field.Type).Interface())
-			if err != nil {
-				return nil, err
-			}
-			headers = append(headers, nestedHeaders...)
-		} else {
-			headers = append(headers, field.Tag.Get("json"))
-		}
+// jobStorePath resolves the export job database path, defaulting to
+// jobs.db in the working directory.
+func jobStorePath() string {
+	if path := os.Getenv("JOBS_DB"); path != "" {
+		return path
 	}
-
-	return headers, nil
+	return defaultJobsDB
 }
 
-func getRows[T any](data []T) ([][]any, error) {
-	var rows [][]any
-
-	for _, item := range data {
-		var row []any
-		v := reflect.ValueOf(item)
-		if v.Kind() == reflect.Ptr {
-			v = v.Elem()
-		}
-
-		for i := range v.NumField() {
-			field := v.Field(i)
-			if !field.IsValid() {
-				return nil, fmt.Errorf("invalid field at position %d", i)
-			}
-
-			row = append(row, field.Interface())
+// exportWorkers resolves how many goroutines run queued export jobs
+// concurrently, defaulting to a modest fixed pool.
+func exportWorkers() int {
+	if v := os.Getenv("EXPORT_WORKERS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
 		}
-
-		rows = append(rows, row)
 	}
-
-	return rows, nil
+	return defaultExportWorkers
 }
 
 func handleError(w http.ResponseWriter, err error, message string, code int) {
@@ -153,6 +163,27 @@ func handleError(w http.ResponseWriter, err error, message string, code int) {
 	http.Error(w, message, code)
 }
 
+// trackingResponseWriter records whether any bytes have reached the client
+// yet. A streamed export (csv/jsonl write straight through; xlsx/ods/pdf
+// write on Close) can fail partway through, and by then the 200 status line
+// may already be committed - writing handleError's textual body onto that
+// started stream would just corrupt it silently. Unwrap lets
+// http.ResponseController see through this wrapper to reach the underlying
+// connection for SetWriteDeadline etc.
+type trackingResponseWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (w *trackingResponseWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.wrote = true
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *trackingResponseWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
 func elapseTime(message string) (start, end func()) {
 	var startTime, endTime time.Time
 
@@ -168,129 +199,114 @@ func elapseTime(message string) (start, end func()) {
 	return start, end
 }
 
-func getData(convocationId string) (*[]DATA, error) {
-	start, end := elapseTime("Fetching data")
-	start()
-
-	var apiResponse []DATA
-
-	// Fetch apiResponse with API_URL and set Authorization header with Bearer token
-	apiURL := os.Getenv("API_URL")
-	token := os.Getenv("API_TOKEN")
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/backoffice/v1/solicitud/excel?convocatoria=%s", apiURL, convocationId), nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
-	}
-
-	log.Printf("Requesting data from %s", req.URL.String())
-
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-
-	client := &http.Client{
-		Timeout: 5 * time.Minute,
+// toAnyRow adapts a []string header row to the []any shape excelize's
+// StreamWriter expects.
+func toAnyRow(values []string) []any {
+	row := make([]any, len(values))
+	for i, v := range values {
+		row[i] = v
 	}
+	return row
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error sending request: %w", err)
-	}
-	defer resp.Body.Close()
+// runExport walks def's upstream pagination page-by-page and streams each
+// page's rows into exporter, so a 100k-row export never holds more than one
+// page of rows in memory at a time. onProgress, if non-nil, is called after
+// every page with the rows written so far and the total the upstream API
+// reported.
+func runExport(ctx context.Context, exporter Exporter, def ReportDefinition, query url.Values, onProgress func(rowsDone, total int)) (int, error) {
+	modelType := modelRegistry[def.Model]
 
-	body, err := io.ReadAll(resp.Body)
+	specs, err := columnSpecsForType(modelType, def.Columns)
 	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %w", err)
+		return 0, fmt.Errorf("error getting field tags: %w", err)
 	}
 
-	contentType := resp.Header.Get("Content-Type")
-	if resp.StatusCode != http.StatusOK {
-		if contentType == "application/json" {
-			var apiError APIError
-			if err := json.Unmarshal(body, &apiError); err != nil {
-				return nil, fmt.Errorf("error decoding error response: %w", err)
-			}
-			return nil, fmt.Errorf("error response from API: %s", apiError.Message)
-		} else {
-			return nil, fmt.Errorf("error response from API: %s", string(body))
+	if styled, ok := exporter.(StyledExporter); ok {
+		if err := styled.WriteColumns(specs); err != nil {
+			return 0, fmt.Errorf("error writing header row: %w", err)
+		}
+	} else {
+		headers, err := headersForType(modelType, def.Columns)
+		if err != nil {
+			return 0, fmt.Errorf("error getting field tags: %w", err)
+		}
+		if err := exporter.WriteHeader(headers); err != nil {
+			return 0, fmt.Errorf("error writing header row: %w", err)
 		}
 	}
 
-	if contentType != "application/json" {
-		return nil, fmt.Errorf("unexpected content type: %s", contentType)
-	}
-
-	// print json idented
-	var prettyJSON bytes.Buffer
-	if err := json.Indent(&prettyJSON, body, "", "  "); err != nil {
-		return nil, fmt.Errorf("error indenting json: %w", err)
+	rowsDone, err := fetchAllPages(ctx, def, modelType, query, exporter, onProgress)
+	if err != nil {
+		return rowsDone, err
 	}
-	log.Printf("Response: %s", prettyJSON.String())
 
-	if err := json.Unmarshal(body, &apiResponse); err != nil {
-		return nil, fmt.Errorf("error decoding response: %w", err)
+	if err := exporter.Close(); err != nil {
+		return rowsDone, err
 	}
 
-	end()
-	return &apiResponse, nil
+	return rowsDone, nil
 }
 
-func downloadFile[T any](w http.ResponseWriter, data []T) error {
-	start, end := elapseTime("Creating file")
+// streamDownload runs an export straight into the HTTP response, setting the
+// response headers from the negotiated Exporter before any bytes are written.
+func streamDownload(ctx context.Context, w http.ResponseWriter, def ReportDefinition, query url.Values, format string) error {
+	start, end := elapseTime("Streaming file")
 	start()
+	defer end()
 
-	file := excelize.NewFile()
-	defer file.Close()
-
-	sheetName := "Sheet1"
-	_, err := file.NewSheet(sheetName)
-
+	exporter, err := newExporter(format, w)
 	if err != nil {
-		handleError(w, err, "Error creating sheet", http.StatusInternalServerError)
+		return err
 	}
 
-	// Set headers
-	headers, err := getHeaders(data[0])
-	if err != nil {
-		return fmt.Errorf("error getting field tags: %w", err)
-	}
-	file.SetSheetRow(sheetName, "A1", &headers)
-
-	// Add rows
-	rows, err := getRows(data)
-	if err != nil {
-		return fmt.Errorf("error getting rows: %w", err)
-	}
-	for i, row := range rows {
-		cell := fmt.Sprintf("A%d", i+2)
-		file.SetSheetRow(sheetName, cell, &row)
-	}
-
-	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-	w.Header().Set("Content-Disposition", "attachment; filename=data.xlsx")
-
-	file.Write(w)
+	w.Header().Set("Content-Type", exporter.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", exporter.Filename()))
 
-	end()
-
-	return nil
+	_, err = runExport(ctx, exporter, def, query, nil)
+	return err
 }
 
-func handler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Downloading data")
+// makeDownloadHandler builds the GET /download/{report} handler bound to
+// registry, so the server isn't hard-coded to any single report or model.
+func makeDownloadHandler(registry *ReportRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println("Downloading data")
+
+		report := r.PathValue("report")
+		def, ok := registry.Get(report)
+		if !ok {
+			handleError(w, nil, fmt.Sprintf("Unknown report %q", report), http.StatusNotFound)
+			return
+		}
 
-	convocationId := r.URL.Query().Get("convocationId")
-	if convocationId == "" {
-		handleError(w, nil, "Missing convocationId", http.StatusBadRequest)
-		return
-	}
+		if err := requireQueryParams(def, r.URL.Query()); err != nil {
+			handleError(w, err, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-	apiResponse, err := getData(convocationId)
-	if err != nil {
-		handleError(w, err, "Error fetching data", http.StatusInternalServerError)
-		return
-	}
+		// The download path streams potentially tens of thousands of rows, which
+		// can outlast the server's global WriteTimeout; extend the deadline for
+		// just this response instead of leaving every other route uncapped.
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Now().Add(30 * time.Minute)); err != nil {
+			log.Printf("unable to extend write deadline: %v", err)
+		}
 
-	if err := downloadFile(w, *apiResponse); err != nil {
-		handleError(w, err, "Error creating file", http.StatusInternalServerError)
-		return
+		format := resolveFormat(r)
+
+		tw := &trackingResponseWriter{ResponseWriter: w}
+		if err := streamDownload(r.Context(), tw, def, r.URL.Query(), format); err != nil {
+			if tw.wrote {
+				// The response has already started; there is no way to signal
+				// failure through the body without corrupting whatever format
+				// is mid-stream, so abort the connection instead of layering
+				// an HTTP error onto it.
+				log.Printf("Error creating file: %v", err)
+				panic(http.ErrAbortHandler)
+			}
+			handleError(w, err, "Error creating file", http.StatusInternalServerError)
+			return
+		}
 	}
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeReportConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "reports.json")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("writing report config: %v", err)
+	}
+	return path
+}
+
+func TestLoadReportRegistry(t *testing.T) {
+	t.Run("valid config loads and is retrievable by report name", func(t *testing.T) {
+		path := writeReportConfig(t, `[
+			{"report": "solicitud", "model": "solicitud", "upstream_path": "/api/solicitud", "query_params": ["convocatoria"]}
+		]`)
+
+		reg, err := loadReportRegistry(path)
+		if err != nil {
+			t.Fatalf("loadReportRegistry: %v", err)
+		}
+
+		def, ok := reg.Get("solicitud")
+		if !ok {
+			t.Fatal("Get(\"solicitud\") = false, want true")
+		}
+		if def.UpstreamPath != "/api/solicitud" {
+			t.Errorf("UpstreamPath = %q, want %q", def.UpstreamPath, "/api/solicitud")
+		}
+
+		if _, ok := reg.Get("unknown"); ok {
+			t.Error("Get(\"unknown\") = true, want false")
+		}
+	})
+
+	t.Run("unknown model is rejected", func(t *testing.T) {
+		path := writeReportConfig(t, `[
+			{"report": "solicitud", "model": "does-not-exist", "upstream_path": "/api/solicitud"}
+		]`)
+
+		if _, err := loadReportRegistry(path); err == nil {
+			t.Fatal("loadReportRegistry() = nil error, want one for an unregistered model")
+		}
+	})
+
+	t.Run("malformed json is rejected", func(t *testing.T) {
+		path := writeReportConfig(t, `not json`)
+
+		if _, err := loadReportRegistry(path); err == nil {
+			t.Fatal("loadReportRegistry() = nil error, want one for invalid json")
+		}
+	})
+
+	t.Run("missing file is rejected", func(t *testing.T) {
+		if _, err := loadReportRegistry(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+			t.Fatal("loadReportRegistry() = nil error, want one for a missing file")
+		}
+	})
+}
+
+func TestRequireQueryParams(t *testing.T) {
+	def := ReportDefinition{Report: "solicitud", QueryParams: []string{"convocatoria"}}
+
+	t.Run("missing required param errors", func(t *testing.T) {
+		if err := requireQueryParams(def, url.Values{}); err == nil {
+			t.Fatal("requireQueryParams() = nil error, want one for a missing param")
+		}
+	})
+
+	t.Run("present required param is accepted", func(t *testing.T) {
+		query := url.Values{"convocatoria": []string{"42"}}
+		if err := requireQueryParams(def, query); err != nil {
+			t.Fatalf("requireQueryParams() = %v, want nil", err)
+		}
+	})
+}
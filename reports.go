@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ReportDefinition describes one exportable report: which upstream endpoint
+// backs it, which of the incoming request's query params it forwards, and
+// which Go model its rows decode into.
+type ReportDefinition struct {
+	Report       string            `json:"report"`
+	Model        string            `json:"model"`
+	UpstreamPath string            `json:"upstream_path"`
+	QueryParams  []string          `json:"query_params"`
+	Columns      map[string]string `json:"columns,omitempty"`
+}
+
+// modelRegistry maps the model name used in the report config to the Go
+// struct whose fields (and json/xlsx tags) drive reflection-based headers and
+// rows. Adding a report for a new upstream entity means adding its struct
+// here and a matching entry in reports.json.
+var modelRegistry = map[string]reflect.Type{
+	"solicitud": reflect.TypeOf(DATA{}),
+}
+
+// ReportRegistry holds the loaded set of ReportDefinitions, keyed by report
+// name (the {report} path segment in GET /download/{report}).
+type ReportRegistry struct {
+	defs map[string]ReportDefinition
+}
+
+// loadReportRegistry reads a JSON array of ReportDefinitions from path and
+// validates that each one references a registered model.
+func loadReportRegistry(path string) (*ReportRegistry, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading report config %q: %w", path, err)
+	}
+
+	var defs []ReportDefinition
+	if err := json.Unmarshal(raw, &defs); err != nil {
+		return nil, fmt.Errorf("error parsing report config %q: %w", path, err)
+	}
+
+	reg := &ReportRegistry{defs: make(map[string]ReportDefinition, len(defs))}
+	for _, def := range defs {
+		if _, ok := modelRegistry[def.Model]; !ok {
+			return nil, fmt.Errorf("report %q references unknown model %q", def.Report, def.Model)
+		}
+		reg.defs[def.Report] = def
+	}
+
+	return reg, nil
+}
+
+// Get looks up a report definition by its path segment.
+func (reg *ReportRegistry) Get(report string) (ReportDefinition, bool) {
+	def, ok := reg.defs[report]
+	return def, ok
+}
+
+// requireQueryParams checks that every query param a report declares as
+// required is present on the incoming request.
+func requireQueryParams(def ReportDefinition, query url.Values) error {
+	for _, name := range def.QueryParams {
+		if query.Get(name) == "" {
+			return fmt.Errorf("missing required query param %q for report %q", name, def.Report)
+		}
+	}
+	return nil
+}
+
+// rawAPIPage is the Laravel-style pagination envelope the upstream API
+// returns; Data is left raw so it can be decoded into whatever model the
+// matching ReportDefinition names.
+type rawAPIPage struct {
+	Data  json.RawMessage `json:"data"`
+	Links Links           `json:"links"`
+	Meta  Meta            `json:"meta"`
+}
+
+// fetchReportPage requests a single page for def, forwarding the report's
+// declared query params plus pagination, and decodes its rows into a slice
+// of modelType.
+func fetchReportPage(ctx context.Context, def ReportDefinition, modelType reflect.Type, query url.Values, page int) (reflect.Value, Links, Meta, error) {
+	apiURL := os.Getenv("API_URL")
+	token := os.Getenv("API_TOKEN")
+
+	upstreamQuery := url.Values{}
+	for _, name := range def.QueryParams {
+		upstreamQuery.Set(name, query.Get(name))
+	}
+	upstreamQuery.Set("page", fmt.Sprintf("%d", page))
+
+	reqURL := fmt.Sprintf("%s%s?%s", apiURL, def.UpstreamPath, upstreamQuery.Encode())
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return reflect.Value{}, Links{}, Meta{}, fmt.Errorf("error creating request: %w", err)
+	}
+
+	log.Printf("Requesting data from %s", req.URL.String())
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	client := &http.Client{
+		Timeout: 5 * time.Minute,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return reflect.Value{}, Links{}, Meta{}, fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return reflect.Value{}, Links{}, Meta{}, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if resp.StatusCode != http.StatusOK {
+		var apiErr error
+		if contentType == "application/json" {
+			var apiError APIError
+			if err := json.Unmarshal(body, &apiError); err != nil {
+				return reflect.Value{}, Links{}, Meta{}, fmt.Errorf("error decoding error response: %w", err)
+			}
+			apiErr = fmt.Errorf("error response from API: %s", apiError.Message)
+		} else {
+			apiErr = fmt.Errorf("error response from API: %s", string(body))
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+			apiErr = &retryableAPIError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")), err: apiErr}
+		}
+		return reflect.Value{}, Links{}, Meta{}, apiErr
+	}
+
+	if contentType != "application/json" {
+		return reflect.Value{}, Links{}, Meta{}, fmt.Errorf("unexpected content type: %s", contentType)
+	}
+
+	// print json idented
+	var prettyJSON bytes.Buffer
+	if err := json.Indent(&prettyJSON, body, "", "  "); err != nil {
+		return reflect.Value{}, Links{}, Meta{}, fmt.Errorf("error indenting json: %w", err)
+	}
+	log.Printf("Response: %s", prettyJSON.String())
+
+	var raw rawAPIPage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return reflect.Value{}, Links{}, Meta{}, fmt.Errorf("error decoding response: %w", err)
+	}
+
+	rows := reflect.New(reflect.SliceOf(modelType))
+	if err := json.Unmarshal(raw.Data, rows.Interface()); err != nil {
+		return reflect.Value{}, Links{}, Meta{}, fmt.Errorf("error decoding rows: %w", err)
+	}
+
+	return rows.Elem(), raw.Links, raw.Meta, nil
+}
+
+// retryableAPIError marks an upstream failure (429 or 5xx) as safe to retry,
+// optionally carrying the delay the API asked for via Retry-After.
+type retryableAPIError struct {
+	RetryAfter time.Duration
+	err        error
+}
+
+func (e *retryableAPIError) Error() string { return e.err.Error() }
+func (e *retryableAPIError) Unwrap() error { return e.err }
+
+// parseRetryAfter reads a Retry-After header, which the HTTP spec allows as
+// either a number of seconds or an HTTP date.
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+const maxFetchAttempts = 5
+
+// fetchReportPageWithRetry wraps fetchReportPage with jittered exponential
+// backoff on 429/5xx responses, honoring Retry-After when the API sends one.
+func fetchReportPageWithRetry(ctx context.Context, def ReportDefinition, modelType reflect.Type, query url.Values, page int) (reflect.Value, Links, Meta, error) {
+	var lastErr error
+
+	for attempt := 0; attempt < maxFetchAttempts; attempt++ {
+		data, links, meta, err := fetchReportPage(ctx, def, modelType, query, page)
+		if err == nil {
+			return data, links, meta, nil
+		}
+
+		var retryable *retryableAPIError
+		if !errors.As(err, &retryable) {
+			return reflect.Value{}, Links{}, Meta{}, err
+		}
+		lastErr = err
+
+		wait := retryable.RetryAfter
+		if wait <= 0 {
+			backoff := (1 << attempt) * 250 * time.Millisecond
+			wait = backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		}
+
+		select {
+		case <-ctx.Done():
+			return reflect.Value{}, Links{}, Meta{}, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+
+	return reflect.Value{}, Links{}, Meta{}, fmt.Errorf("giving up after %d attempts: %w", maxFetchAttempts, lastErr)
+}
+
+// fetchConcurrency resolves how many pages are fetched in parallel, defaulting
+// to GOMAXPROCS so the pool scales with the host by default.
+func fetchConcurrency() int {
+	if v := os.Getenv("FETCH_CONCURRENCY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// fetchAllPages fetches page 1 to learn the upstream's total page count, then
+// fans the remaining pages out across a bounded worker pool, applying each
+// page's rows to exporter strictly in page order so streamed output stays
+// deterministic even though the fetches complete out of order.
+func fetchAllPages(ctx context.Context, def ReportDefinition, modelType reflect.Type, query url.Values, exporter Exporter, onProgress func(rowsDone, total int)) (int, error) {
+	data, links, meta, err := fetchReportPageWithRetry(ctx, def, modelType, query, 1)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching page 1: %w", err)
+	}
+
+	rowsDone, err := writePageRows(exporter, data)
+	if err != nil {
+		return rowsDone, err
+	}
+	if onProgress != nil {
+		onProgress(rowsDone, meta.Total)
+	}
+
+	if links.Next == nil || meta.LastPage <= 1 {
+		return rowsDone, nil
+	}
+
+	type pageResult struct {
+		page int
+		data reflect.Value
+		err  error
+	}
+
+	results := make(chan pageResult, meta.LastPage-1)
+	sem := make(chan struct{}, fetchConcurrency())
+	g, gctx := errgroup.WithContext(ctx)
+
+	for page := 2; page <= meta.LastPage; page++ {
+		page := page
+		g.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			data, _, _, err := fetchReportPageWithRetry(gctx, def, modelType, query, page)
+			results <- pageResult{page: page, data: data, err: err}
+			return err
+		})
+	}
+
+	go func() {
+		g.Wait()
+		close(results)
+	}()
+
+	pending := make(map[int]reflect.Value)
+	next := 2
+	for res := range results {
+		if res.err != nil {
+			return rowsDone, fmt.Errorf("error fetching page %d: %w", res.page, res.err)
+		}
+
+		pending[res.page] = res.data
+		for {
+			pageData, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+
+			written, err := writePageRows(exporter, pageData)
+			if err != nil {
+				return rowsDone, err
+			}
+			rowsDone += written
+			if onProgress != nil {
+				onProgress(rowsDone, meta.Total)
+			}
+			next++
+		}
+	}
+
+	return rowsDone, nil
+}
+
+// writePageRows converts one page's decoded rows and writes them to exporter.
+func writePageRows(exporter Exporter, data reflect.Value) (int, error) {
+	rows, err := rowsForValue(data)
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows: %w", err)
+	}
+	for _, row := range rows {
+		if err := exporter.WriteRow(row); err != nil {
+			return 0, fmt.Errorf("error writing row: %w", err)
+		}
+	}
+	return len(rows), nil
+}
+
+// ColumnSpec is one field's parsed `xlsx:"..."` tag: a display header plus
+// the formatting hints spreadsheet exporters use to style that column.
+//
+// Tag grammar: `xlsx:"Header[,key=value]*"`, e.g.
+// `xlsx:"Fecha Nacimiento,format=date,width=18,style=bold"` or
+// `xlsx:"Puntaje,format=number;0.00"`.
+type ColumnSpec struct {
+	Header string
+	Format string // "date", or "number;<excel numFmt>"
+	Width  float64
+	Bold   bool
+}
+
+// parseXLSXTag parses a single field's xlsx tag into a ColumnSpec.
+func parseXLSXTag(tag string) ColumnSpec {
+	parts := strings.Split(tag, ",")
+	spec := ColumnSpec{Header: parts[0]}
+
+	for _, part := range parts[1:] {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "format":
+			spec.Format = value
+		case "width":
+			if width, err := strconv.ParseFloat(value, 64); err == nil {
+				spec.Width = width
+			}
+		case "style":
+			spec.Bold = spec.Bold || value == "bold"
+		}
+	}
+
+	return spec
+}
+
+// columnSpecsForType walks t's fields via reflection to build one ColumnSpec
+// per column, applying any header overrides the report definition supplies.
+func columnSpecsForType(t reflect.Type, overrides map[string]string) ([]ColumnSpec, error) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var specs []ColumnSpec
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Type.Kind() == reflect.Struct && field.Anonymous {
+			nested, err := columnSpecsForType(field.Type, overrides)
+			if err != nil {
+				return nil, err
+			}
+			specs = append(specs, nested...)
+			continue
+		}
+
+		spec := parseXLSXTag(field.Tag.Get("xlsx"))
+		if spec.Header == "" {
+			spec.Header = field.Tag.Get("json")
+		}
+		if override, ok := overrides[field.Tag.Get("json")]; ok {
+			spec.Header = override
+		}
+		specs = append(specs, spec)
+	}
+
+	return specs, nil
+}
+
+// headersForType returns just the display headers, for exporters (csv,
+// jsonl, pdf) that have no use for xlsx-specific styling metadata.
+func headersForType(t reflect.Type, overrides map[string]string) ([]string, error) {
+	specs, err := columnSpecsForType(t, overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make([]string, len(specs))
+	for i, spec := range specs {
+		headers[i] = spec.Header
+	}
+	return headers, nil
+}
+
+// rowsForValue converts a reflect.Value slice of structs into the [][]any
+// shape the Exporter interface writes.
+func rowsForValue(v reflect.Value) ([][]any, error) {
+	var rows [][]any
+
+	for i := 0; i < v.Len(); i++ {
+		item := v.Index(i)
+		if item.Kind() == reflect.Ptr {
+			item = item.Elem()
+		}
+
+		var row []any
+		for i := range item.NumField() {
+			field := item.Field(i)
+			if !field.IsValid() {
+				return nil, fmt.Errorf("invalid field at position %d", i)
+			}
+			row = append(row, field.Interface())
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
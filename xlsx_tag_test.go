@@ -0,0 +1,130 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseXLSXTag(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  string
+		want ColumnSpec
+	}{
+		{
+			name: "header only",
+			tag:  "Nombres",
+			want: ColumnSpec{Header: "Nombres"},
+		},
+		{
+			name: "date with width and bold style",
+			tag:  "Fecha Nacimiento,format=date,width=18,style=bold",
+			want: ColumnSpec{Header: "Fecha Nacimiento", Format: "date", Width: 18, Bold: true},
+		},
+		{
+			name: "number with explicit excel numFmt",
+			tag:  "Puntaje,format=number;0.00",
+			want: ColumnSpec{Header: "Puntaje", Format: "number;0.00"},
+		},
+		{
+			name: "unknown key is ignored",
+			tag:  "Campus,color=blue",
+			want: ColumnSpec{Header: "Campus"},
+		},
+		{
+			name: "malformed width falls back to zero",
+			tag:  "Edad,width=not-a-number",
+			want: ColumnSpec{Header: "Edad"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseXLSXTag(tt.tag); got != tt.want {
+				t.Errorf("parseXLSXTag(%q) = %+v, want %+v", tt.tag, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColumnSpecsForType(t *testing.T) {
+	overrides := map[string]string{"convocatoria_id": "ID"}
+
+	specs, err := columnSpecsForType(reflect.TypeOf(DATA{}), overrides)
+	if err != nil {
+		t.Fatalf("columnSpecsForType: %v", err)
+	}
+	if len(specs) != reflect.TypeOf(DATA{}).NumField() {
+		t.Fatalf("got %d specs, want %d", len(specs), reflect.TypeOf(DATA{}).NumField())
+	}
+	if specs[0].Header != "ID" {
+		t.Errorf("override not applied: Header = %q, want %q", specs[0].Header, "ID")
+	}
+
+	var fechaNacimiento, puntaje ColumnSpec
+	for _, spec := range specs {
+		switch spec.Header {
+		case "Fecha Nacimiento":
+			fechaNacimiento = spec
+		case "Puntaje":
+			puntaje = spec
+		}
+	}
+	if fechaNacimiento.Format != "date" {
+		t.Errorf("Fecha Nacimiento format = %q, want %q", fechaNacimiento.Format, "date")
+	}
+	if puntaje.Format != "number;0.00" {
+		t.Errorf("Puntaje format = %q, want %q", puntaje.Format, "number;0.00")
+	}
+}
+
+func TestAsDateValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  any
+	}{
+		{
+			name:  "ISO date string converts to time.Time",
+			value: "1990-05-02",
+			want:  time.Date(1990, 5, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "unparseable string is left as-is",
+			value: "not-a-date",
+			want:  "not-a-date",
+		},
+		{
+			name:  "non-string value is left as-is",
+			value: 42,
+			want:  42,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := asDateValue(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("asDateValue(%v) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNumFmtForSpec(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{format: "date", want: "yyyy-mm-dd"},
+		{format: "number;0.00", want: "0.00"},
+		{format: "number", want: "0.00"},
+		{format: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := numFmtForSpec(tt.format); got != tt.want {
+			t.Errorf("numFmtForSpec(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}